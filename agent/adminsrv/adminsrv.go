@@ -0,0 +1,199 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+// Package adminsrv exposes a running license.Agent over a local control
+// plane, bound by default to a UNIX socket, so operators can inspect and
+// nudge license state without restarting the host process.
+package adminsrv
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/mainflux/license"
+	"github.com/mainflux/license/errors"
+)
+
+// DefaultAddr is used when New is given an empty addr.
+const DefaultAddr = "unix:/run/mainflux-license.sock"
+
+// secretHeader carries the shared secret required of TCP mode requests.
+const secretHeader = "X-License-Admin-Secret"
+
+var errMissingSecret = errors.New("missing or invalid admin secret")
+
+// Server serves the agent admin API: GET /license, POST /reload,
+// POST /validate and GET /healthz.
+type Server struct {
+	agent   license.Agent
+	network string
+	address string
+	secret  string
+	srv     *http.Server
+}
+
+// Option customizes the Server returned by New.
+type Option func(*Server)
+
+// WithSharedSecret requires TCP mode requests to carry secret in the
+// X-License-Admin-Secret header. Ignored in UNIX socket mode, where access
+// is instead gated by filesystem permissions on the socket.
+func WithSharedSecret(secret string) Option {
+	return func(s *Server) {
+		s.secret = secret
+	}
+}
+
+// New returns a Server for agent, listening on addr. addr follows a
+// "family:address" convention: "unix:/path/to.sock" or "tcp::8080". An
+// empty addr defaults to DefaultAddr.
+func New(agent license.Agent, addr string, opts ...Option) (*Server, error) {
+	if addr == "" {
+		addr = DefaultAddr
+	}
+	network, address, err := parseAddr(addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{agent: agent, network: network, address: address}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/license", s.handleLicense)
+	mux.HandleFunc("/reload", s.handleReload)
+	mux.HandleFunc("/validate", s.handleValidate)
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	s.srv = &http.Server{Handler: s.authenticate(mux)}
+
+	return s, nil
+}
+
+// parseAddr splits a "family:address" string into a net.Listen network and
+// address, e.g. "unix:/run/x.sock" -> ("unix", "/run/x.sock") and
+// "tcp::8080" -> ("tcp", ":8080").
+func parseAddr(addr string) (network, address string, err error) {
+	parts := strings.SplitN(addr, ":", 2)
+	if len(parts) != 2 {
+		return "", "", errors.New("address must be in \"family:address\" form, e.g. unix:/run/x.sock")
+	}
+	return parts[0], parts[1], nil
+}
+
+// ListenAndServe starts serving the admin API and blocks until the
+// listener fails or Close is called.
+func (s *Server) ListenAndServe() error {
+	if s.network == "unix" {
+		if err := os.RemoveAll(s.address); err != nil {
+			return err
+		}
+	}
+	ln, err := net.Listen(s.network, s.address)
+	if err != nil {
+		return err
+	}
+	if s.network == "unix" {
+		if err := os.Chmod(s.address, 0o600); err != nil {
+			ln.Close()
+			return err
+		}
+	}
+
+	return s.srv.Serve(ln)
+}
+
+// Close shuts down the admin server, and removes the UNIX socket file, if
+// any.
+func (s *Server) Close() error {
+	if s.srv == nil {
+		return nil
+	}
+	err := s.srv.Close()
+	if s.network == "unix" {
+		os.RemoveAll(s.address)
+	}
+	return err
+}
+
+// authenticate enforces the shared-secret header in TCP mode. UNIX socket
+// mode relies on filesystem permissions on the socket instead.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.network == "tcp" && s.secret != "" && r.Header.Get(secretHeader) != s.secret {
+			http.Error(w, errMissingSecret.Error(), http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleLicense returns the currently loaded license as JSON. License
+// carries no signature material itself - that is verified and discarded
+// before a license is ever loaded - so there is nothing to redact.
+func (s *Server) handleLicense(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	l, ok := s.agent.Current()
+	if !ok {
+		http.Error(w, "no license loaded", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(l)
+}
+
+func (s *Server) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if err := s.agent.Load(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+type validateRequest struct {
+	Services []string `json:"services"`
+}
+
+func (s *Server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	var req validateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := s.agent.Validate(req.Services); err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{"valid": false, "error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"valid": true})
+}
+
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	if err := s.agent.ValidateClaim(context.Background(), license.Claim{}); err != nil {
+		switch {
+		case errors.Contains(err, license.ErrLicenseInGrace):
+			status = "degraded"
+		default:
+			status = "expired"
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": status})
+}
@@ -0,0 +1,297 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package adminsrv
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mainflux/license"
+	"github.com/mainflux/license/errors"
+)
+
+func TestParseAddr(t *testing.T) {
+	cases := []struct {
+		addr    string
+		network string
+		address string
+		wantErr bool
+	}{
+		{addr: "unix:/run/mainflux-license.sock", network: "unix", address: "/run/mainflux-license.sock"},
+		{addr: "tcp::8080", network: "tcp", address: ":8080"},
+		{addr: "malformed", wantErr: true},
+	}
+	for _, c := range cases {
+		network, address, err := parseAddr(c.addr)
+		if c.wantErr {
+			if err == nil {
+				t.Errorf("parseAddr(%q): expected error, got nil", c.addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAddr(%q): unexpected error: %s", c.addr, err)
+			continue
+		}
+		if network != c.network || address != c.address {
+			t.Errorf("parseAddr(%q) = (%q, %q), want (%q, %q)", c.addr, network, address, c.network, c.address)
+		}
+	}
+}
+
+// fakeAgent is a hand-rolled license.Agent double, so adminsrv's handlers
+// can be tested in isolation from the real agent's command loop.
+type fakeAgent struct {
+	current       license.License
+	hasCurrent    bool
+	loadErr       error
+	validateErr   error
+	lastServices  []string
+	validateSvcFn func(services []string) error
+}
+
+func (a *fakeAgent) Do()         {}
+func (a *fakeAgent) Save() error { return nil }
+func (a *fakeAgent) Load() error { return a.loadErr }
+func (a *fakeAgent) Current() (license.License, bool) {
+	return a.current, a.hasCurrent
+}
+func (a *fakeAgent) Validate(services []string) error {
+	a.lastServices = services
+	if a.validateSvcFn != nil {
+		return a.validateSvcFn(services)
+	}
+	return nil
+}
+func (a *fakeAgent) ValidateClaim(ctx context.Context, claim license.Claim) error {
+	return a.validateErr
+}
+func (a *fakeAgent) StartRefresh(ctx context.Context, interval, grace time.Duration) error {
+	return nil
+}
+func (a *fakeAgent) StopRefresh()                 {}
+func (a *fakeAgent) Events() <-chan license.Event { return nil }
+
+var _ license.Agent = (*fakeAgent)(nil)
+
+func TestHandleLicense(t *testing.T) {
+	s := &Server{agent: &fakeAgent{current: license.License{Services: []string{"users"}}, hasCurrent: true}}
+
+	rr := httptest.NewRecorder()
+	s.handleLicense(rr, httptest.NewRequest(http.MethodGet, "/license", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	var got license.License
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(got.Services) != 1 || got.Services[0] != "users" {
+		t.Fatalf("unexpected license: %+v", got)
+	}
+}
+
+func TestHandleLicenseNoneLoaded(t *testing.T) {
+	s := &Server{agent: &fakeAgent{}}
+
+	rr := httptest.NewRecorder()
+	s.handleLicense(rr, httptest.NewRequest(http.MethodGet, "/license", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected 404, got %d", rr.Code)
+	}
+}
+
+func TestHandleLicenseWrongMethod(t *testing.T) {
+	s := &Server{agent: &fakeAgent{}}
+
+	rr := httptest.NewRecorder()
+	s.handleLicense(rr, httptest.NewRequest(http.MethodPost, "/license", nil))
+
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405, got %d", rr.Code)
+	}
+}
+
+func TestHandleReload(t *testing.T) {
+	s := &Server{agent: &fakeAgent{}}
+
+	rr := httptest.NewRecorder()
+	s.handleReload(rr, httptest.NewRequest(http.MethodPost, "/reload", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+}
+
+func TestHandleReloadError(t *testing.T) {
+	s := &Server{agent: &fakeAgent{loadErr: errors.New("fetch failed")}}
+
+	rr := httptest.NewRecorder()
+	s.handleReload(rr, httptest.NewRequest(http.MethodPost, "/reload", nil))
+
+	if rr.Code != http.StatusBadGateway {
+		t.Fatalf("expected 502, got %d", rr.Code)
+	}
+}
+
+func TestHandleValidate(t *testing.T) {
+	a := &fakeAgent{}
+	s := &Server{agent: a}
+
+	body := strings.NewReader(`{"services":["users","things"]}`)
+	rr := httptest.NewRecorder()
+	s.handleValidate(rr, httptest.NewRequest(http.MethodPost, "/validate", body))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+	if len(a.lastServices) != 2 || a.lastServices[0] != "users" || a.lastServices[1] != "things" {
+		t.Fatalf("unexpected services passed to Validate: %v", a.lastServices)
+	}
+	var got map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got["valid"] != true {
+		t.Fatalf("expected valid=true, got %+v", got)
+	}
+}
+
+func TestHandleValidateRejected(t *testing.T) {
+	s := &Server{agent: &fakeAgent{validateSvcFn: func(services []string) error {
+		return errors.New("service not allowed")
+	}}}
+
+	body := strings.NewReader(`{"services":["unknown"]}`)
+	rr := httptest.NewRecorder()
+	s.handleValidate(rr, httptest.NewRequest(http.MethodPost, "/validate", body))
+
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422, got %d", rr.Code)
+	}
+	var got map[string]interface{}
+	if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got["valid"] != false {
+		t.Fatalf("expected valid=false, got %+v", got)
+	}
+}
+
+func TestHandleValidateMalformedBody(t *testing.T) {
+	s := &Server{agent: &fakeAgent{}}
+
+	rr := httptest.NewRecorder()
+	s.handleValidate(rr, httptest.NewRequest(http.MethodPost, "/validate", strings.NewReader("not json")))
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", rr.Code)
+	}
+}
+
+func TestHandleHealthz(t *testing.T) {
+	cases := []struct {
+		name        string
+		validateErr error
+		want        string
+	}{
+		{name: "ok", validateErr: nil, want: "ok"},
+		{name: "degraded", validateErr: errors.Wrap(license.ErrLicenseInGrace, license.ErrLicenseExpired), want: "degraded"},
+		{name: "expired", validateErr: license.ErrLicenseExpired, want: "expired"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := &Server{agent: &fakeAgent{validateErr: c.validateErr}}
+
+			rr := httptest.NewRecorder()
+			s.handleHealthz(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+
+			var got map[string]string
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if got["status"] != c.want {
+				t.Fatalf("expected status %q, got %q", c.want, got["status"])
+			}
+		})
+	}
+}
+
+func TestAuthenticateTCPRejectsMissingSecret(t *testing.T) {
+	s := &Server{network: "tcp", secret: "s3cr3t"}
+	handler := s.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/license", nil))
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rr.Code)
+	}
+}
+
+func TestAuthenticateTCPAcceptsMatchingSecret(t *testing.T) {
+	s := &Server{network: "tcp", secret: "s3cr3t"}
+	handler := s.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/license", nil)
+	req.Header.Set(secretHeader, "s3cr3t")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+}
+
+func TestAuthenticateUnixSkipsSecretCheck(t *testing.T) {
+	s := &Server{network: "unix", secret: "s3cr3t"}
+	handler := s.authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/license", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rr.Code)
+	}
+}
+
+func TestListenAndServeUnixSocketPermissions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	s, err := New(&fakeAgent{}, "unix:"+path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	go s.ListenAndServe()
+	defer s.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		fi, err := os.Stat(path)
+		if err == nil {
+			if fi.Mode().Perm() != 0o600 {
+				t.Fatalf("expected socket mode 0600, got %o", fi.Mode().Perm())
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("socket was never created: %s", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -4,50 +4,153 @@
 package agent
 
 import (
+	"context"
+	"crypto/ed25519"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
-	"os"
+	"sync"
+	"time"
 
 	"github.com/mainflux/license"
 	"github.com/mainflux/license/errors"
+	"github.com/mainflux/license/store"
 )
 
-var (
-	errServiceNotAllowed = errors.New("service not allowed")
-	errLicenseNotLoaded  = errors.New("license not loaded")
+var errLicenseNotLoaded = errors.New("license not loaded")
+
+// ErrTransientFetch wraps fetch errors that are worth retrying, such as
+// network failures or 5xx responses from the license service.
+var ErrTransientFetch = errors.New("transient error fetching license")
+
+// ErrTerminalFetch wraps fetch errors that retrying will not fix, such as
+// a 4xx response from the license service.
+var ErrTerminalFetch = errors.New("terminal error fetching license")
+
+const (
+	defaultMaxRetries     = 3
+	defaultRetryBaseDelay = 500 * time.Millisecond
+	defaultTimeout        = 10 * time.Second
+
+	// eventBuffer bounds the Events() channel so a slow or absent consumer
+	// does not block the refresh loop.
+	eventBuffer = 16
 )
+
 var _ license.Agent = (*agent)(nil)
 
 type action uint
 
 type command struct {
+	ctx    context.Context
 	action action
 	params []string
+	claim  license.Claim
 }
 
 const (
 	read action = iota
 	write
 	validate
+	validateClaim
+	refresh
+	current
 )
 
 type agent struct {
-	svcURL   string
-	location string
-	id       string
-	key      string
-	commands chan command
-	errs     chan error
-	license  *license.License
-	crypto   license.Crypto
-}
-
-// New returns new License agent.
-func New(svcURL, location string, id, key string, crypto license.Crypto) license.Agent {
-	return &agent{
+	svcURL         string
+	location       string
+	id             string
+	key            string
+	commands       chan command
+	errs           chan error
+	license        *license.License
+	rawData        []byte
+	crypto         license.Crypto
+	trustedKeys    map[string]ed25519.PublicKey
+	store          license.Store
+	httpClient     *http.Client
+	hasHTTPClient  bool
+	maxRetries     int
+	retryBaseDelay time.Duration
+
+	events        chan license.Event
+	refreshMu     sync.Mutex
+	refreshCancel context.CancelFunc
+	grace         time.Duration
+
+	currentResult chan license.License
+}
+
+// Option customizes the agent returned by New.
+type Option func(*agent)
+
+// WithTrustedKeys configures the set of public keys, indexed by key id,
+// that the agent accepts when verifying signed license envelopes. Without
+// this option the agent only understands the symmetric-encrypted blob
+// format.
+func WithTrustedKeys(keys map[string]ed25519.PublicKey) Option {
+	return func(a *agent) {
+		a.trustedKeys = keys
+	}
+}
+
+// WithMaxRetries sets how many times fetch retries a transient failure
+// before giving up. Defaults to 3.
+func WithMaxRetries(n int) Option {
+	return func(a *agent) {
+		a.maxRetries = n
+	}
+}
+
+// WithRetryBaseDelay sets the base delay used to compute the exponential
+// backoff between fetch retries. Defaults to 500ms.
+func WithRetryBaseDelay(d time.Duration) Option {
+	return func(a *agent) {
+		a.retryBaseDelay = d
+	}
+}
+
+// WithTimeout sets the per-request timeout of the agent's HTTP client.
+// Defaults to 10s. Ignored if WithHTTPClient is also given, regardless of
+// the order the two options are passed to New.
+func WithTimeout(d time.Duration) Option {
+	return func(a *agent) {
+		if a.hasHTTPClient {
+			return
+		}
+		a.httpClient.Timeout = d
+	}
+}
+
+// WithHTTPClient overrides the *http.Client used by fetch, e.g. to share a
+// transport across agents or to inject a client under test. Takes
+// precedence over WithTimeout regardless of option order.
+func WithHTTPClient(client *http.Client) Option {
+	return func(a *agent) {
+		a.httpClient = client
+		a.hasHTTPClient = true
+	}
+}
+
+// WithStore overrides where the agent persists its license blob. By
+// default the agent stores it in the local file named by location, but any
+// license.Store can be substituted, e.g. to share one license across
+// replicas in a clustered deployment.
+func WithStore(s license.Store) Option {
+	return func(a *agent) {
+		a.store = s
+	}
+}
+
+// New returns new License agent. The returned agent keeps a single
+// *http.Client, and thus a single underlying *http.Transport, alive across
+// fetches so TCP/TLS connections to svcURL are reused.
+func New(svcURL, location string, id, key string, crypto license.Crypto, opts ...Option) license.Agent {
+	a := &agent{
 		svcURL:   svcURL,
 		location: location,
 		id:       id,
@@ -55,7 +158,19 @@ func New(svcURL, location string, id, key string, crypto license.Crypto) license
 		commands: make(chan command),
 		errs:     make(chan error),
 		crypto:   crypto,
+		store:    store.NewFile(location),
+		httpClient: &http.Client{
+			Timeout: defaultTimeout,
+		},
+		maxRetries:     defaultMaxRetries,
+		retryBaseDelay: defaultRetryBaseDelay,
+		events:         make(chan license.Event, eventBuffer),
+		currentResult:  make(chan license.License),
+	}
+	for _, opt := range opts {
+		opt(a)
 	}
+	return a
 }
 
 func (a *agent) Do() {
@@ -65,59 +180,220 @@ func (a *agent) Do() {
 		switch cmd.action {
 		case read:
 			var l license.License
-			l, err = a.load()
+			l, err = a.load(cmd.ctx)
 			if err == nil {
 				a.license = &l
 			}
 		case validate:
-			err = a.validate(cmd.params)
+			err = a.validateServices(cmd.params)
+		case validateClaim:
+			err = a.checkClaim(cmd.claim)
 		case write:
-			err = a.save()
+			err = a.saveContext(cmd.ctx)
+		case refresh:
+			err = a.doRefresh(cmd.ctx)
+		case current:
+			if a.license == nil {
+				err = errLicenseNotLoaded
+				a.currentResult <- license.License{}
+			} else {
+				a.currentResult <- *a.license
+			}
 		}
 		a.errs <- err
 	}
 }
 
 func (a *agent) Load() error {
-	return a.command(read)
+	return a.LoadContext(context.Background())
+}
+
+// LoadContext is like Load but binds the request, and any retries, to ctx,
+// so callers can bound or cancel a fetch from the license service.
+func (a *agent) LoadContext(ctx context.Context) error {
+	return a.command(ctx, read)
 }
 
 func (a *agent) Save() error {
-	return a.command(write)
+	return a.command(context.Background(), write)
 }
 
+// Validate checks that the loaded license is valid and grants each of the
+// given services. It predates structured claims and is kept as a shim over
+// ValidateClaim for existing callers.
 func (a *agent) Validate(services []string) error {
-	return a.command(validate, services...)
+	return a.command(context.Background(), validate, services...)
+}
+
+// ValidateClaim checks that the loaded license is valid and grants the
+// entitlements described by claim.
+func (a *agent) ValidateClaim(ctx context.Context, claim license.Claim) error {
+	return a.commandClaim(ctx, claim)
+}
+
+// Current returns the currently loaded license, if any.
+func (a *agent) Current() (license.License, bool) {
+	cmd := command{ctx: context.Background(), action: current}
+	a.commands <- cmd
+	l := <-a.currentResult
+	return l, <-a.errs == nil
+}
+
+// StartRefresh begins a background goroutine that re-fetches the license
+// every interval. StartRefresh is idempotent: calling it while a refresh
+// loop is already running restarts it with the new interval and grace.
+func (a *agent) StartRefresh(ctx context.Context, interval, grace time.Duration) error {
+	a.refreshMu.Lock()
+	defer a.refreshMu.Unlock()
+	if a.refreshCancel != nil {
+		a.refreshCancel()
+	}
+	a.grace = grace
+	rctx, cancel := context.WithCancel(ctx)
+	a.refreshCancel = cancel
+	go a.refreshLoop(rctx, interval)
+	return nil
+}
+
+// StopRefresh stops a running refresh loop. It is a no-op if none is running.
+func (a *agent) StopRefresh() {
+	a.refreshMu.Lock()
+	defer a.refreshMu.Unlock()
+	if a.refreshCancel != nil {
+		a.refreshCancel()
+		a.refreshCancel = nil
+	}
+}
+
+// Events returns the channel on which refresh outcomes are reported.
+func (a *agent) Events() <-chan license.Event {
+	return a.events
+}
+
+func (a *agent) refreshLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.command(ctx, refresh)
+		}
+	}
+}
+
+// doRefresh re-fetches the license from svcURL and persists it, tolerating
+// a currently loaded license that has expired for up to a.grace.
+func (a *agent) doRefresh(ctx context.Context) error {
+	data, err := a.fetch(ctx)
+	if err != nil {
+		switch {
+		case a.inGrace():
+			a.emit(license.Event{Kind: license.Degraded, Err: err})
+			return nil
+		case a.isExpired():
+			a.emit(license.Event{Kind: license.Expired, Err: err})
+			return err
+		default:
+			// No license is loaded, or the loaded one has not expired
+			// yet, so the fetch error is returned without emitting an
+			// event.
+			return err
+		}
+	}
+	payload, err := a.decode(data)
+	if err != nil {
+		return err
+	}
+	l := license.License{}
+	if err := json.Unmarshal(payload, &l); err != nil {
+		return err
+	}
+	a.license = &l
+	a.rawData = data
+	if err := a.saveContext(ctx); err != nil {
+		return err
+	}
+	a.emit(license.Event{Kind: license.Refreshed})
+	return nil
+}
+
+// inGrace reports whether the currently loaded license has expired but is
+// still within its configured refresh grace period.
+func (a *agent) inGrace() bool {
+	if a.license == nil || a.license.ExpiresAt.IsZero() {
+		return false
+	}
+	now := time.Now()
+	return now.After(a.license.ExpiresAt) && now.Before(a.license.ExpiresAt.Add(a.grace))
+}
+
+// isExpired reports whether the currently loaded license has expired,
+// regardless of whether it is still within its refresh grace period.
+func (a *agent) isExpired() bool {
+	if a.license == nil || a.license.ExpiresAt.IsZero() {
+		return false
+	}
+	return time.Now().After(a.license.ExpiresAt)
+}
+
+func (a *agent) emit(e license.Event) {
+	select {
+	case a.events <- e:
+	default:
+	}
 }
 
 // Unlike their exported counterparts, methods load, save, and validate are not thread-safe.
 // These methods are meant to be executed as command handlers in Do method.
-func (a *agent) load() (license.License, error) {
-	data, err := ioutil.ReadFile(a.location)
+func (a *agent) load(ctx context.Context) (license.License, error) {
+	data, err := a.store.Read(ctx)
 	switch {
 	case err == nil:
 		break
-	case os.IsNotExist(err):
-		data, err = a.fetch()
+	case errors.Contains(err, license.ErrStoreNotFound):
+		data, err = a.fetch(ctx)
 		if err != nil {
 			return license.License{}, err
 		}
 	default:
 		return license.License{}, err
 	}
-	data, err = a.crypto.Decrypt(data)
+	payload, err := a.decode(data)
 	if err != nil {
 		return license.License{}, err
 	}
 	l := license.License{}
-	err = json.Unmarshal(data, &l)
-	return l, err
+	if err := json.Unmarshal(payload, &l); err != nil {
+		return license.License{}, err
+	}
+	a.rawData = data
+	return l, nil
+}
+
+// decode returns the plaintext license payload carried by data. Data is
+// either a signed envelope, verified against the agent's trusted key set,
+// or a symmetric-encrypted blob decrypted with a.crypto.
+func (a *agent) decode(data []byte) ([]byte, error) {
+	if env, ok := license.DecodeEnvelope(data); ok {
+		return env.Verify(a.trustedKeys)
+	}
+	return a.crypto.Decrypt(data)
 }
 
-func (a *agent) save() error {
+// saveContext persists the currently loaded license. If it was loaded from
+// a signed envelope, the verified envelope bytes are round-tripped as-is
+// rather than re-wrapped with the symmetric codec, so a later load still
+// goes through signature verification instead of silently downgrading to
+// the unsigned blob format.
+func (a *agent) saveContext(ctx context.Context) error {
 	if a.license == nil {
 		return errLicenseNotLoaded
 	}
+	if _, ok := license.DecodeEnvelope(a.rawData); ok {
+		return a.store.Write(ctx, a.rawData)
+	}
 	data, err := json.Marshal(*a.license)
 	if err != nil {
 		return err
@@ -126,34 +402,42 @@ func (a *agent) save() error {
 	if err != nil {
 		return err
 	}
-	if err := ioutil.WriteFile(a.location, data, os.ModePerm); err != nil {
+	return a.store.Write(ctx, data)
+}
+
+// validateServices checks that the license is valid and grants each of the
+// given services, preserving the membership-only behavior Validate had
+// before structured claims were introduced.
+func (a *agent) validateServices(services []string) error {
+	if err := a.checkClaim(license.Claim{}); err != nil {
 		return err
 	}
+	for _, s := range services {
+		if err := a.checkClaim(license.Claim{Service: s}); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func (a *agent) validate(params []string) error {
+// checkClaim validates the loaded license against claim, honoring the
+// agent's grace period on an otherwise-expired license.
+func (a *agent) checkClaim(claim license.Claim) error {
 	if a.license == nil {
 		return errors.Wrap(license.ErrLicenseValidation, errLicenseNotLoaded)
 	}
-	if err := a.license.Validate(); err != nil {
-		return err
-	}
-	valid := true
-	for _, p := range params {
-		if !exists(p, a.license.Services) {
-			valid = false
-			break
+	if err := a.license.ValidateClaim(claim); err != nil {
+		if errors.Contains(err, license.ErrLicenseExpired) && a.inGrace() {
+			return errors.Wrap(license.ErrLicenseInGrace, err)
 		}
-	}
-	if !valid {
-		return errors.Wrap(license.ErrLicenseValidation, errServiceNotAllowed)
+		return err
 	}
 	return nil
 }
 
-func (a *agent) command(act action, params ...string) error {
+func (a *agent) command(ctx context.Context, act action, params ...string) error {
 	cmd := command{
+		ctx:    ctx,
 		action: act,
 		params: params,
 	}
@@ -161,34 +445,89 @@ func (a *agent) command(act action, params ...string) error {
 	return <-a.errs
 }
 
-func (a *agent) fetch() ([]byte, error) {
+func (a *agent) commandClaim(ctx context.Context, claim license.Claim) error {
+	cmd := command{
+		ctx:    ctx,
+		action: validateClaim,
+		claim:  claim,
+	}
+	a.commands <- cmd
+	return <-a.errs
+}
+
+// fetch retrieves the license from svcURL, retrying transient failures
+// (network errors and 5xx responses) with exponential backoff and jitter,
+// bounded by a.maxRetries. 4xx responses are treated as terminal and
+// returned immediately.
+func (a *agent) fetch(ctx context.Context) ([]byte, error) {
+	var lastErr error
+	for attempt := 0; attempt <= a.maxRetries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, a.backoff(attempt)); err != nil {
+				return nil, err
+			}
+		}
+		data, transient, err := a.fetchOnce(ctx)
+		if err == nil {
+			return data, nil
+		}
+		if !transient {
+			return nil, errors.Wrap(ErrTerminalFetch, err)
+		}
+		lastErr = err
+	}
+	return nil, errors.Wrap(ErrTransientFetch, lastErr)
+}
+
+// fetchOnce performs a single request and reports whether a failure is
+// worth retrying.
+func (a *agent) fetchOnce(ctx context.Context) (data []byte, transient bool, err error) {
 	url := fmt.Sprintf("%s/%s", a.svcURL, a.id)
-	req, err := http.NewRequest(http.MethodGet, url, nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	k, err := a.crypto.Encrypt([]byte(a.key))
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	key := hex.EncodeToString(k)
 	req.Header.Set("Authorization", key)
-	res, err := http.DefaultClient.Do(req)
-	if res != nil {
-		defer res.Body.Close()
-	}
+
+	res, err := a.httpClient.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, true, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		_, _ = ioutil.ReadAll(res.Body)
+		return nil, true, fmt.Errorf("license service returned %s", res.Status)
+	}
+	if res.StatusCode >= http.StatusBadRequest {
+		_, _ = ioutil.ReadAll(res.Body)
+		return nil, false, fmt.Errorf("license service returned %s", res.Status)
 	}
-	return ioutil.ReadAll(res.Body)
+	data, err = ioutil.ReadAll(res.Body)
+	return data, false, err
 }
 
-func exists(p string, services []string) bool {
-	for _, s := range services {
-		if string(p) == s {
-			return true
-		}
+// backoff returns the exponential backoff delay, with jitter, for the
+// given retry attempt (1-indexed).
+func (a *agent) backoff(attempt int) time.Duration {
+	delay := a.retryBaseDelay * (1 << uint(attempt-1))
+	jitter := time.Duration(rand.Int63n(int64(delay)/2 + 1))
+	return delay + jitter
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
 	}
-	return false
-}
\ No newline at end of file
+}
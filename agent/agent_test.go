@@ -0,0 +1,366 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package agent
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/mainflux/license"
+)
+
+type nopCrypto struct{}
+
+func (nopCrypto) Encrypt(b []byte) ([]byte, error) { return b, nil }
+func (nopCrypto) Decrypt(b []byte) ([]byte, error) { return b, nil }
+
+func writeLicenseFile(t *testing.T, data []byte) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "license")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f.Close()
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	return f.Name()
+}
+
+func signedLicense(t *testing.T, kid string, key ed25519.PrivateKey) []byte {
+	t.Helper()
+	l := license.License{Services: []string{"users"}}
+	payload, err := json.Marshal(l)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	env := license.NewSigner(kid, key).Sign(payload)
+	data, err := json.Marshal(env)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return data
+}
+
+func TestLoadSignedEnvelope(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	path := writeLicenseFile(t, signedLicense(t, "key-1", priv))
+
+	a := New("", path, "", "", nopCrypto{}, WithTrustedKeys(map[string]ed25519.PublicKey{"key-1": pub})).(*agent)
+	l, err := a.load(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(l.Services) != 1 || l.Services[0] != "users" {
+		t.Fatalf("unexpected license: %+v", l)
+	}
+}
+
+func TestLoadSignedEnvelopeKeyRotation(t *testing.T) {
+	_, oldPriv, _ := ed25519.GenerateKey(nil)
+	newPub, newPriv, _ := ed25519.GenerateKey(nil)
+	path := writeLicenseFile(t, signedLicense(t, "key-2", newPriv))
+
+	trusted := map[string]ed25519.PublicKey{"key-2": newPub}
+	a := New("", path, "", "", nopCrypto{}, WithTrustedKeys(trusted)).(*agent)
+	if _, err := a.load(context.Background()); err != nil {
+		t.Fatalf("unexpected error with rotated key: %s", err)
+	}
+	_ = oldPriv // old key no longer trusted
+}
+
+func TestLoadSignedEnvelopeUnknownKid(t *testing.T) {
+	_, priv, _ := ed25519.GenerateKey(nil)
+	path := writeLicenseFile(t, signedLicense(t, "key-3", priv))
+
+	a := New("", path, "", "", nopCrypto{}, WithTrustedKeys(map[string]ed25519.PublicKey{})).(*agent)
+	if _, err := a.load(context.Background()); err == nil {
+		t.Fatal("expected error for unknown kid, got nil")
+	}
+}
+
+func TestLoadSignedEnvelopeTamperedPayload(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	data := signedLicense(t, "key-4", priv)
+
+	var env license.Envelope
+	if err := json.Unmarshal(data, &env); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tampered := license.License{Services: []string{"admin"}}
+	env.Payload, _ = json.Marshal(tampered)
+	data, _ = json.Marshal(env)
+	path := writeLicenseFile(t, data)
+
+	a := New("", path, "", "", nopCrypto{}, WithTrustedKeys(map[string]ed25519.PublicKey{"key-4": pub})).(*agent)
+	if _, err := a.load(context.Background()); err == nil {
+		t.Fatal("expected error for tampered payload, got nil")
+	}
+}
+
+func TestSaveContextPreservesSignedEnvelope(t *testing.T) {
+	pub, priv, _ := ed25519.GenerateKey(nil)
+	data := signedLicense(t, "key-5", priv)
+	path := writeLicenseFile(t, data)
+
+	a := New("", path, "", "", nopCrypto{}, WithTrustedKeys(map[string]ed25519.PublicKey{"key-5": pub})).(*agent)
+	l, err := a.load(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	a.license = &l
+	if err := a.saveContext(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	saved, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := license.DecodeEnvelope(saved); !ok {
+		t.Fatal("expected saved license to remain a signed envelope")
+	}
+
+	// Simulate a restart: a fresh agent with no trusted keys must reject the
+	// persisted license, proving it was not silently downgraded to the
+	// unsigned symmetric blob format.
+	untrusted := New("", path, "", "", nopCrypto{}, WithTrustedKeys(map[string]ed25519.PublicKey{})).(*agent)
+	if _, err := untrusted.load(context.Background()); err == nil {
+		t.Fatal("expected signature verification to still be enforced after save")
+	}
+}
+
+func TestFetchRetriesTransientErrors(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"services":["users"]}`))
+	}))
+	defer srv.Close()
+
+	a := New(srv.URL, filepath.Join(t.TempDir(), "license"), "dev", "key", nopCrypto{},
+		WithMaxRetries(3), WithRetryBaseDelay(time.Millisecond)).(*agent)
+	if _, err := a.fetch(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := atomic.LoadInt32(&hits); got != 3 {
+		t.Fatalf("expected 3 attempts, got %d", got)
+	}
+}
+
+func TestFetchTerminalErrorNotRetried(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	a := New(srv.URL, filepath.Join(t.TempDir(), "license"), "dev", "key", nopCrypto{},
+		WithMaxRetries(3), WithRetryBaseDelay(time.Millisecond)).(*agent)
+	if _, err := a.fetch(context.Background()); err == nil {
+		t.Fatal("expected terminal error, got nil")
+	}
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Fatalf("expected exactly 1 attempt for a terminal error, got %d", got)
+	}
+}
+
+func newLoadedAgent(t *testing.T, l license.License) *agent {
+	t.Helper()
+	a := New("", filepath.Join(t.TempDir(), "license"), "", "", nopCrypto{}).(*agent)
+	go a.Do()
+	data, err := json.Marshal(l)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := a.store.Write(context.Background(), data); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := a.Load(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return a
+}
+
+func TestValidateClaimFeature(t *testing.T) {
+	a := newLoadedAgent(t, license.License{
+		Services: []string{"users"},
+		Features: map[string]bool{"analytics": true, "export": false},
+		Quotas:   map[string]int64{"analytics": 10},
+	})
+
+	if err := a.ValidateClaim(context.Background(), license.Claim{Feature: "analytics", Quantity: 5}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := a.ValidateClaim(context.Background(), license.Claim{Feature: "analytics", Quantity: 20}); err == nil {
+		t.Fatal("expected quota exceeded error, got nil")
+	}
+	if err := a.ValidateClaim(context.Background(), license.Claim{Feature: "export"}); err == nil {
+		t.Fatal("expected feature disabled error, got nil")
+	}
+}
+
+func TestDoRefreshEmitsRefreshedOnSuccess(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"services":["users"]}`))
+	}))
+	defer srv.Close()
+
+	a := New(srv.URL, filepath.Join(t.TempDir(), "license"), "dev", "key", nopCrypto{}).(*agent)
+	if err := a.doRefresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case e := <-a.Events():
+		if e.Kind != license.Refreshed {
+			t.Fatalf("expected Refreshed event, got %+v", e)
+		}
+	default:
+		t.Fatal("expected a Refreshed event, got none")
+	}
+}
+
+func TestDoRefreshTransientFailureFarFromExpiryEmitsNoEvent(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	a := New(srv.URL, filepath.Join(t.TempDir(), "license"), "dev", "key", nopCrypto{},
+		WithMaxRetries(0)).(*agent)
+	a.license = &license.License{Services: []string{"users"}, ExpiresAt: time.Now().Add(30 * 24 * time.Hour)}
+	a.grace = time.Hour
+
+	if err := a.doRefresh(context.Background()); err == nil {
+		t.Fatal("expected transient fetch error, got nil")
+	}
+	select {
+	case e := <-a.Events():
+		t.Fatalf("expected no event for a transient failure far from expiry, got %+v", e)
+	default:
+	}
+}
+
+func TestDoRefreshFailureWithinGraceEmitsDegraded(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	a := New(srv.URL, filepath.Join(t.TempDir(), "license"), "dev", "key", nopCrypto{},
+		WithMaxRetries(0)).(*agent)
+	a.license = &license.License{Services: []string{"users"}, ExpiresAt: time.Now().Add(-time.Minute)}
+	a.grace = time.Hour
+
+	if err := a.doRefresh(context.Background()); err != nil {
+		t.Fatalf("unexpected error while within grace: %s", err)
+	}
+	select {
+	case e := <-a.Events():
+		if e.Kind != license.Degraded {
+			t.Fatalf("expected Degraded event, got %+v", e)
+		}
+	default:
+		t.Fatal("expected a Degraded event, got none")
+	}
+}
+
+func TestDoRefreshFailurePastGraceEmitsExpired(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	a := New(srv.URL, filepath.Join(t.TempDir(), "license"), "dev", "key", nopCrypto{},
+		WithMaxRetries(0)).(*agent)
+	a.license = &license.License{Services: []string{"users"}, ExpiresAt: time.Now().Add(-2 * time.Hour)}
+	a.grace = time.Minute
+
+	if err := a.doRefresh(context.Background()); err == nil {
+		t.Fatal("expected fetch error past grace, got nil")
+	}
+	select {
+	case e := <-a.Events():
+		if e.Kind != license.Expired {
+			t.Fatalf("expected Expired event, got %+v", e)
+		}
+	default:
+		t.Fatal("expected an Expired event, got none")
+	}
+}
+
+func TestStartStopRefresh(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Write([]byte(`{"services":["users"]}`))
+	}))
+	defer srv.Close()
+
+	a := New(srv.URL, filepath.Join(t.TempDir(), "license"), "dev", "key", nopCrypto{}).(*agent)
+	go a.Do()
+
+	if err := a.StartRefresh(context.Background(), 10*time.Millisecond, time.Hour); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	select {
+	case e := <-a.Events():
+		if e.Kind != license.Refreshed {
+			t.Fatalf("expected Refreshed event, got %+v", e)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for a refresh")
+	}
+
+	a.StopRefresh()
+	if got := atomic.LoadInt32(&hits); got == 0 {
+		t.Fatal("expected at least one refresh before stopping")
+	}
+	time.Sleep(20 * time.Millisecond)
+	stopped := atomic.LoadInt32(&hits)
+	time.Sleep(50 * time.Millisecond)
+	if got := atomic.LoadInt32(&hits); got != stopped {
+		t.Fatalf("expected refreshing to stop, hits went from %d to %d", stopped, got)
+	}
+
+	// StopRefresh must be idempotent, both when called again after a stop
+	// and when no refresh loop was ever started.
+	a.StopRefresh()
+	New(srv.URL, filepath.Join(t.TempDir(), "license"), "dev", "key", nopCrypto{}).(*agent).StopRefresh()
+}
+
+func TestValidateClaimVersion(t *testing.T) {
+	a := newLoadedAgent(t, license.License{
+		Services:   []string{"users"},
+		MinVersion: "1.2.0",
+		MaxVersion: "2.0.0",
+	})
+
+	if err := a.ValidateClaim(context.Background(), license.Claim{Version: "1.5.0"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := a.ValidateClaim(context.Background(), license.Claim{Version: "1.0.0"}); err == nil {
+		t.Fatal("expected version unsupported error, got nil")
+	}
+	if err := a.ValidateClaim(context.Background(), license.Claim{Version: "2.1.0"}); err == nil {
+		t.Fatal("expected version unsupported error, got nil")
+	}
+}
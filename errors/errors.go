@@ -0,0 +1,96 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+// Package errors provides a way to wrap and compare errors while preserving
+// the original error chain, similar in spirit to errors.Wrap in other
+// Mainflux services.
+package errors
+
+// Error specifies an API that must be fulfilled by error type.
+type Error interface {
+	error
+
+	// Msg returns the top-level error message, without the wrapped errors.
+	Msg() string
+
+	// Err returns the wrapped error, if any.
+	Err() Error
+}
+
+var _ Error = (*customError)(nil)
+
+type customError struct {
+	msg string
+	err Error
+}
+
+// New returns an Error that formats as the given text.
+func New(text string) Error {
+	return &customError{
+		msg: text,
+		err: nil,
+	}
+}
+
+// Wrap returns an Error that contains both wrapper and wrapped errors.
+// If wrapper is nil, Wrap returns nil. If wrapped is nil, Wrap returns
+// wrapper unchanged.
+func Wrap(wrapper, wrapped error) error {
+	if wrapper == nil {
+		return nil
+	}
+	if wrapped == nil {
+		return wrapper
+	}
+	return &customError{
+		msg: wrapper.Error(),
+		err: Cast(wrapped),
+	}
+}
+
+// Cast casts a generic error to an Error.
+func Cast(err error) Error {
+	if err == nil {
+		return nil
+	}
+	if e, ok := err.(Error); ok {
+		return e
+	}
+	return &customError{msg: err.Error()}
+}
+
+// Contains inspects if e2 error is contained in any layer of e1 error.
+func Contains(e1, e2 error) bool {
+	if e1 == nil || e2 == nil {
+		return e1 == e2
+	}
+	ce, ok := e1.(Error)
+	if !ok {
+		return e1.Error() == e2.Error()
+	}
+	for ce != nil {
+		if ce.Msg() == e2.Error() {
+			return true
+		}
+		ce = ce.Err()
+	}
+	return false
+}
+
+func (ce *customError) Error() string {
+	if ce == nil {
+		return ""
+	}
+	if ce.err == nil {
+		return ce.msg
+	}
+	return ce.msg + " : " + ce.err.Error()
+}
+
+func (ce *customError) Msg() string {
+	return ce.msg
+}
+
+func (ce *customError) Err() Error {
+	return ce.err
+}
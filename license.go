@@ -0,0 +1,300 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+// Package license defines the domain model shared by the license agent and
+// the license issuing service.
+package license
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mainflux/license/errors"
+)
+
+// ErrLicenseValidation indicates that a license failed validation.
+var ErrLicenseValidation = errors.New("license validation failed")
+
+// ErrLicenseExpired indicates that a license has passed its expiry date.
+var ErrLicenseExpired = errors.New("license expired")
+
+// ErrStoreNotFound indicates that a Store has no license persisted yet.
+var ErrStoreNotFound = errors.New("no license found in store")
+
+// ErrLicenseInGrace indicates that the currently loaded license has expired
+// but is still being honored because it falls within the agent's configured
+// refresh grace period. Callers may choose to log or warn on this error
+// instead of hard-failing.
+var ErrLicenseInGrace = errors.New("license expired, operating within grace period")
+
+// ErrEnvelopeVerification indicates that a signed license envelope could
+// not be verified.
+var ErrEnvelopeVerification = errors.New("license envelope verification failed")
+
+// ErrFeatureDisabled indicates that the license does not entitle the
+// deployment to a requested feature.
+var ErrFeatureDisabled = errors.New("feature not enabled by license")
+
+// ErrQuotaExceeded indicates that a claimed quantity exceeds the quota the
+// license grants for that feature.
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+// ErrVersionUnsupported indicates that the claimed version falls outside
+// the [MinVersion, MaxVersion] range the license allows.
+var ErrVersionUnsupported = errors.New("version unsupported by license")
+
+// ErrServiceNotAllowed indicates that the license's service list does not
+// include the claimed service.
+var ErrServiceNotAllowed = errors.New("service not allowed")
+
+var (
+	errUnsupportedAlg    = errors.New("unsupported signature algorithm")
+	errUnknownKid        = errors.New("unknown signing key id")
+	errSignatureMismatch = errors.New("signature does not match payload")
+)
+
+// AlgEdDSA is the only signature algorithm currently supported by Envelope.
+const AlgEdDSA = "EdDSA"
+
+// Envelope is a compact JWS-style signed license envelope, used as an
+// alternative to the symmetric-encrypted blob format. Payload and Sig are
+// base64-encoded by encoding/json since they are byte slices.
+type Envelope struct {
+	Payload []byte `json:"payload"`
+	Sig     []byte `json:"sig"`
+	Alg     string `json:"alg"`
+	Kid     string `json:"kid"`
+}
+
+// DecodeEnvelope reports whether data is a signed Envelope rather than a
+// symmetric-encrypted blob, and returns the decoded envelope if so.
+func DecodeEnvelope(data []byte) (Envelope, bool) {
+	var env Envelope
+	if err := json.Unmarshal(data, &env); err != nil || env.Alg == "" {
+		return Envelope{}, false
+	}
+	return env, true
+}
+
+// Verify checks the envelope signature against the trusted public key set,
+// indexed by key id, and returns the verified license payload.
+func (e Envelope) Verify(trusted map[string]ed25519.PublicKey) ([]byte, error) {
+	if e.Alg != AlgEdDSA {
+		return nil, errors.Wrap(ErrEnvelopeVerification, errUnsupportedAlg)
+	}
+	pub, ok := trusted[e.Kid]
+	if !ok {
+		return nil, errors.Wrap(ErrEnvelopeVerification, errUnknownKid)
+	}
+	if !ed25519.Verify(pub, e.Payload, e.Sig) {
+		return nil, errors.Wrap(ErrEnvelopeVerification, errSignatureMismatch)
+	}
+	return e.Payload, nil
+}
+
+// Signer signs license payloads on behalf of the license service, producing
+// Envelopes that agents can verify offline against the corresponding
+// public key.
+type Signer struct {
+	kid string
+	key ed25519.PrivateKey
+}
+
+// NewSigner returns a Signer that signs with key under the given key id.
+// The kid must match an entry in the verifying agent's trusted key set.
+func NewSigner(kid string, key ed25519.PrivateKey) Signer {
+	return Signer{kid: kid, key: key}
+}
+
+// Sign wraps payload (typically a marshalled License) into a signed
+// Envelope.
+func (s Signer) Sign(payload []byte) Envelope {
+	return Envelope{
+		Payload: payload,
+		Sig:     ed25519.Sign(s.key, payload),
+		Alg:     AlgEdDSA,
+		Kid:     s.kid,
+	}
+}
+
+// License represents a Mainflux license issued to a deployment. It carries
+// the set of services the deployment is entitled to run, the date after
+// which it must be renewed, and the entitlements - feature flags, quotas
+// and supported version range - that gate individual capabilities.
+type License struct {
+	Services   []string         `json:"services"`
+	ExpiresAt  time.Time        `json:"expires_at"`
+	Features   map[string]bool  `json:"features,omitempty"`
+	Quotas     map[string]int64 `json:"quotas,omitempty"`
+	MinVersion string           `json:"min_version,omitempty"`
+	MaxVersion string           `json:"max_version,omitempty"`
+}
+
+// Validate checks that the license has not expired.
+func (l License) Validate() error {
+	if !l.ExpiresAt.IsZero() && time.Now().After(l.ExpiresAt) {
+		return errors.Wrap(ErrLicenseValidation, ErrLicenseExpired)
+	}
+	return nil
+}
+
+// Claim describes the entitlement a caller wants to exercise: a service
+// name, an optional feature flag, an optional quantity to check against a
+// quota named after Feature, and an optional caller version to check
+// against the license's supported version range. Zero-valued fields are
+// not checked.
+type Claim struct {
+	Service  string
+	Feature  string
+	Quantity int64
+	Version  string
+}
+
+// ValidateClaim checks that the license grants the entitlements described
+// by claim, in addition to the base expiry check performed by Validate.
+func (l License) ValidateClaim(claim Claim) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	if claim.Service != "" && !stringIn(claim.Service, l.Services) {
+		return errors.Wrap(ErrLicenseValidation, ErrServiceNotAllowed)
+	}
+	if claim.Feature != "" {
+		if enabled, ok := l.Features[claim.Feature]; !ok || !enabled {
+			return errors.Wrap(ErrFeatureDisabled, fmt.Errorf("feature %q", claim.Feature))
+		}
+		if claim.Quantity > 0 {
+			if quota, ok := l.Quotas[claim.Feature]; ok && claim.Quantity > quota {
+				return errors.Wrap(ErrQuotaExceeded, fmt.Errorf("%q: %d exceeds quota %d", claim.Feature, claim.Quantity, quota))
+			}
+		}
+	}
+	if claim.Version != "" {
+		if l.MinVersion != "" && compareVersions(claim.Version, l.MinVersion) < 0 {
+			return errors.Wrap(ErrVersionUnsupported, fmt.Errorf("%s is below minimum version %s", claim.Version, l.MinVersion))
+		}
+		if l.MaxVersion != "" && compareVersions(claim.Version, l.MaxVersion) > 0 {
+			return errors.Wrap(ErrVersionUnsupported, fmt.Errorf("%s is above maximum version %s", claim.Version, l.MaxVersion))
+		}
+	}
+	return nil
+}
+
+func stringIn(s string, list []string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// compareVersions compares two dot-separated numeric version strings,
+// returning -1, 0 or 1 as a is less than, equal to, or greater than b.
+// Missing components are treated as 0, so "1.2" == "1.2.0".
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var an, bn int
+		if i < len(as) {
+			an, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			bn, _ = strconv.Atoi(bs[i])
+		}
+		if an != bn {
+			if an < bn {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// Crypto encrypts and decrypts the license payload exchanged between the
+// license service and the agent.
+type Crypto interface {
+	Encrypt([]byte) ([]byte, error)
+	Decrypt([]byte) ([]byte, error)
+}
+
+// Store persists the raw (encrypted or enveloped) license blob on behalf of
+// an Agent, decoupling it from any particular storage backend.
+type Store interface {
+	// Read returns the persisted license blob, or an error wrapping
+	// ErrStoreNotFound if none has been written yet.
+	Read(ctx context.Context) ([]byte, error)
+
+	// Write persists the license blob, overwriting any previous value.
+	Write(ctx context.Context, data []byte) error
+
+	// Watch returns a channel that receives a value whenever the stored
+	// license blob changes. The channel is closed when ctx is done.
+	Watch(ctx context.Context) (<-chan struct{}, error)
+}
+
+// EventKind identifies the kind of Event emitted by a running refresh loop.
+type EventKind int
+
+const (
+	// Refreshed is emitted after a successful scheduled re-fetch.
+	Refreshed EventKind = iota
+	// Degraded is emitted when a scheduled re-fetch failed but the current
+	// license is still being honored within its grace period.
+	Degraded
+	// Expired is emitted when a scheduled re-fetch failed and the current
+	// license has exhausted its grace period.
+	Expired
+)
+
+// Event reports the outcome of a scheduled refresh.
+type Event struct {
+	Kind EventKind
+	Err  error
+}
+
+// Agent loads, persists and validates a license on behalf of the services
+// running on a deployment.
+type Agent interface {
+	// Do runs the command loop. It must be started in its own goroutine.
+	Do()
+
+	// Load reads the license from the agent's Store or, if the Store has
+	// none yet, fetches it from the license service, then decrypts it or
+	// verifies its signed envelope.
+	Load() error
+
+	// Save persists the currently loaded license to the agent's Store.
+	Save() error
+
+	// Validate checks that the loaded license is valid and grants each of
+	// the given services. It predates structured claims and is kept as a
+	// shim over ValidateClaim for existing callers.
+	Validate(services []string) error
+
+	// ValidateClaim checks that the loaded license is valid and grants the
+	// entitlements described by claim.
+	ValidateClaim(ctx context.Context, claim Claim) error
+
+	// Current returns the currently loaded license, if any.
+	Current() (License, bool)
+
+	// StartRefresh begins periodically re-fetching the license from the
+	// license service every interval, before it expires. If the service is
+	// unreachable, the currently loaded license continues to be honored for
+	// up to grace past its expiry.
+	StartRefresh(ctx context.Context, interval, grace time.Duration) error
+
+	// StopRefresh stops a refresh loop started by StartRefresh. It is a
+	// no-op if no refresh loop is running.
+	StopRefresh()
+
+	// Events returns the channel on which refresh outcomes are reported.
+	Events() <-chan Event
+}
@@ -0,0 +1,45 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+
+	"github.com/mainflux/license"
+	"github.com/mainflux/license/errors"
+)
+
+// ErrWatchUnsupported indicates that a Store cannot notify on external
+// changes and Watch was called anyway.
+var ErrWatchUnsupported = errors.New("store does not support watching for changes")
+
+type envVarStore struct {
+	name string
+}
+
+// NewEnvVar returns a Store that reads and writes the license blob,
+// base64-encoded, to the named environment variable. Write only affects
+// the current process's environment; it does not persist across restarts
+// unless the caller also exports it into the process's launch environment.
+func NewEnvVar(name string) license.Store {
+	return &envVarStore{name: name}
+}
+
+func (s *envVarStore) Read(ctx context.Context) ([]byte, error) {
+	v, ok := os.LookupEnv(s.name)
+	if !ok {
+		return nil, license.ErrStoreNotFound
+	}
+	return base64.StdEncoding.DecodeString(v)
+}
+
+func (s *envVarStore) Write(ctx context.Context, data []byte) error {
+	return os.Setenv(s.name, base64.StdEncoding.EncodeToString(data))
+}
+
+func (s *envVarStore) Watch(ctx context.Context) (<-chan struct{}, error) {
+	return nil, ErrWatchUnsupported
+}
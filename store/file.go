@@ -0,0 +1,76 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+// Package store provides license.Store implementations so an agent's
+// license blob can be persisted somewhere other than a local file, e.g. to
+// share one license across replicas in a clustered deployment.
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/mainflux/license"
+	"github.com/mainflux/license/errors"
+)
+
+// pollInterval is how often the file store checks for external changes,
+// since the filesystem backend offers no native notifications here.
+const pollInterval = time.Second
+
+type fileStore struct {
+	path string
+}
+
+// NewFile returns a Store that persists the license blob to a local file.
+// This is the storage backend the agent has always used.
+func NewFile(path string) license.Store {
+	return &fileStore{path: path}
+}
+
+func (s *fileStore) Read(ctx context.Context) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, errors.Wrap(license.ErrStoreNotFound, err)
+	}
+	return data, err
+}
+
+func (s *fileStore) Write(ctx context.Context, data []byte) error {
+	return ioutil.WriteFile(s.path, data, os.ModePerm)
+}
+
+func (s *fileStore) Watch(ctx context.Context) (<-chan struct{}, error) {
+	ch := make(chan struct{})
+	go func() {
+		defer close(ch)
+		var lastMod time.Time
+		if fi, err := os.Stat(s.path); err == nil {
+			lastMod = fi.ModTime()
+		}
+		ticker := time.NewTicker(pollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fi, err := os.Stat(s.path)
+				if err != nil {
+					continue
+				}
+				if fi.ModTime().After(lastMod) {
+					lastMod = fi.ModTime()
+					select {
+					case ch <- struct{}{}:
+					case <-ctx.Done():
+						return
+					}
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
@@ -0,0 +1,51 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"context"
+
+	"github.com/mainflux/license"
+)
+
+// KVDriver is the minimal interface a distributed key-value backend (e.g.
+// Consul or etcd) must implement to back a Store. Concrete drivers live
+// outside this package so it has no dependency on any particular client
+// library.
+type KVDriver interface {
+	// Get returns the value stored under key, or license.ErrStoreNotFound
+	// if it does not exist.
+	Get(ctx context.Context, key string) ([]byte, error)
+
+	// Put sets the value stored under key.
+	Put(ctx context.Context, key string, value []byte) error
+
+	// Watch returns a channel that receives a value whenever the value
+	// under key changes.
+	Watch(ctx context.Context, key string) (<-chan struct{}, error)
+}
+
+type kvStore struct {
+	driver KVDriver
+	key    string
+}
+
+// NewKV returns a Store backed by driver, so operators running clustered
+// deployments can share one license across replicas without a shared
+// filesystem.
+func NewKV(driver KVDriver, key string) license.Store {
+	return &kvStore{driver: driver, key: key}
+}
+
+func (s *kvStore) Read(ctx context.Context) ([]byte, error) {
+	return s.driver.Get(ctx, s.key)
+}
+
+func (s *kvStore) Write(ctx context.Context, data []byte) error {
+	return s.driver.Put(ctx, s.key, data)
+}
+
+func (s *kvStore) Watch(ctx context.Context) (<-chan struct{}, error) {
+	return s.driver.Watch(ctx, s.key)
+}
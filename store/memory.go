@@ -0,0 +1,74 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mainflux/license"
+)
+
+type memoryStore struct {
+	mu       sync.Mutex
+	data     []byte
+	set      bool
+	watchers []chan struct{}
+}
+
+// NewMemory returns an in-memory Store, useful in tests and anywhere a
+// license only needs to live for the lifetime of the process.
+func NewMemory() license.Store {
+	return &memoryStore{}
+}
+
+func (s *memoryStore) Read(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.set {
+		return nil, license.ErrStoreNotFound
+	}
+	data := make([]byte, len(s.data))
+	copy(data, s.data)
+	return data, nil
+}
+
+func (s *memoryStore) Write(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data = append([]byte(nil), data...)
+	s.set = true
+
+	for _, w := range s.watchers {
+		select {
+		case w <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Watch(ctx context.Context) (<-chan struct{}, error) {
+	ch := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.watchers = append(s.watchers, ch)
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, w := range s.watchers {
+			if w == ch {
+				watchers := make([]chan struct{}, 0, len(s.watchers)-1)
+				watchers = append(watchers, s.watchers[:i]...)
+				watchers = append(watchers, s.watchers[i+1:]...)
+				s.watchers = watchers
+				break
+			}
+		}
+		close(ch)
+	}()
+	return ch, nil
+}
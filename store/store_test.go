@@ -0,0 +1,105 @@
+// Copyright (c) Mainflux
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/mainflux/license"
+	"github.com/mainflux/license/errors"
+)
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "license")
+	s := NewFile(path)
+	ctx := context.Background()
+
+	if _, err := s.Read(ctx); !errors.Contains(err, license.ErrStoreNotFound) {
+		t.Fatalf("expected ErrStoreNotFound, got %v", err)
+	}
+	if err := s.Write(ctx, []byte("blob")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := s.Read(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(got) != "blob" {
+		t.Fatalf("expected %q, got %q", "blob", got)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemory()
+	ctx := context.Background()
+
+	if _, err := s.Read(ctx); !errors.Contains(err, license.ErrStoreNotFound) {
+		t.Fatalf("expected ErrStoreNotFound, got %v", err)
+	}
+	if err := s.Write(ctx, []byte("blob")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := s.Read(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(got) != "blob" {
+		t.Fatalf("expected %q, got %q", "blob", got)
+	}
+}
+
+func TestMemoryStoreWatchUnwatchRace(t *testing.T) {
+	s := NewMemory()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			if _, err := s.Watch(ctx); err != nil {
+				t.Errorf("unexpected error: %s", err)
+				return
+			}
+		}()
+	}
+
+	for i := 0; i < 10; i++ {
+		if err := s.Write(context.Background(), []byte("blob")); err != nil {
+			t.Errorf("unexpected error: %s", err)
+		}
+	}
+	wg.Wait()
+}
+
+func TestEnvVarStore(t *testing.T) {
+	const name = "MAINFLUX_LICENSE_TEST_BLOB"
+	os.Unsetenv(name)
+	defer os.Unsetenv(name)
+
+	s := NewEnvVar(name)
+	ctx := context.Background()
+
+	if _, err := s.Read(ctx); !errors.Contains(err, license.ErrStoreNotFound) {
+		t.Fatalf("expected ErrStoreNotFound, got %v", err)
+	}
+	if err := s.Write(ctx, []byte("blob")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := s.Read(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(got) != "blob" {
+		t.Fatalf("expected %q, got %q", "blob", got)
+	}
+	if _, err := s.Watch(ctx); err != ErrWatchUnsupported {
+		t.Fatalf("expected ErrWatchUnsupported, got %v", err)
+	}
+}